@@ -0,0 +1,107 @@
+package main
+
+import "encoding/json"
+import "fmt"
+import "net/http"
+import "net/url"
+import "sync/atomic"
+
+// start_dashboard serves the opt-in operator UI and JSON API on addr. It
+// runs for the lifetime of the process; a bind failure is logged, not
+// fatal, since a crawl shouldn't die just because the dashboard couldn't
+// start.
+func (c * Crawler) start_dashboard (addr string) {
+  mux := http.NewServeMux()
+
+  mux.HandleFunc("/", dashboard_index)
+  mux.HandleFunc("/api/stats", c.api_stats)
+  mux.HandleFunc("/api/domains", c.api_domains)
+  mux.HandleFunc("/api/pause", c.api_pause)
+  mux.HandleFunc("/api/resume", c.api_resume)
+  mux.HandleFunc("/api/enqueue", c.api_enqueue)
+
+  go func(){
+    if err := http.ListenAndServe(addr, mux); err != nil {
+      fmt.Printf("dashboard: %v\n", err)
+    }
+  }()
+}
+
+func (c * Crawler) api_stats (w http.ResponseWriter, req * http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  json.NewEncoder(w).Encode(map[string]interface{}{
+    "success":        atomic.LoadInt64(&c.dashboard_stats.success),
+    "errors":         atomic.LoadInt64(&c.dashboard_stats.errors),
+    "ignored":        atomic.LoadInt64(&c.dashboard_stats.ignored),
+    "queue_length":   atomic.LoadInt64(&c.pending_count),
+    "active_workers": atomic.LoadInt64(&c.active_workers),
+    "pool_size":      c.pool_size,
+    "paused":         c.is_paused(),
+  })
+}
+
+func (c * Crawler) api_domains (w http.ResponseWriter, req * http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+  json.NewEncoder(w).Encode(c.dashboard_stats.Domains())
+}
+
+func (c * Crawler) api_pause (w http.ResponseWriter, req * http.Request) {
+  c.set_paused(true)
+  w.WriteHeader(http.StatusNoContent)
+}
+
+func (c * Crawler) api_resume (w http.ResponseWriter, req * http.Request) {
+  c.set_paused(false)
+  w.WriteHeader(http.StatusNoContent)
+}
+
+func (c * Crawler) api_enqueue (w http.ResponseWriter, req * http.Request) {
+  if req.Method != http.MethodPost {
+    http.Error(w, "POST only", http.StatusMethodNotAllowed)
+    return
+  }
+
+  var body struct{ Url string `json:"url"` }
+  if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+
+  u, err := url.Parse(body.Url)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+
+  if !c.enqueue_if_active(u, nil, LinkPrimary, 0) {
+    http.Error(w, "crawl has finished", http.StatusServiceUnavailable)
+    return
+  }
+  w.WriteHeader(http.StatusNoContent)
+}
+
+const dashboard_html = `<!DOCTYPE html>
+<html>
+<head><title>butler</title></head>
+<body>
+<h1>butler crawl status</h1>
+<pre id="stats">loading...</pre>
+<button onclick="fetch('/api/pause',{method:'POST'})">Pause</button>
+<button onclick="fetch('/api/resume',{method:'POST'})">Resume</button>
+<script>
+function refresh() {
+  fetch('/api/stats').then(r => r.json()).then(s => {
+    document.getElementById('stats').textContent = JSON.stringify(s, null, 2)
+  })
+}
+setInterval(refresh, 1000)
+refresh()
+</script>
+</body>
+</html>`
+
+func dashboard_index (w http.ResponseWriter, req * http.Request) {
+  w.Header().Set("Content-Type", "text/html")
+  w.Write([]byte(dashboard_html))
+}
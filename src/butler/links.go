@@ -0,0 +1,111 @@
+package main
+
+import "bytes"
+import "regexp"
+import "strings"
+
+import "golang.org/x/net/html"
+
+// LinkKind distinguishes a page worth crawling on its own (LinkPrimary)
+// from an embedded resource that only matters for completeness of an
+// archive crawl (LinkRelated).
+type LinkKind int
+
+const (
+  LinkPrimary LinkKind = iota
+  LinkRelated
+)
+
+func (k LinkKind) String () (string) {
+  if k == LinkPrimary {
+    return "primary"
+  }
+  return "related"
+}
+
+// LinkRule names an attribute on an HTML tag that may carry a URL, and
+// the kind of link found there.
+type LinkRule struct {
+  Tag  string
+  Attr string
+  Kind LinkKind
+}
+
+var link_rules = [] LinkRule {
+  {"a",      "href", LinkPrimary},
+  {"link",   "href", LinkRelated},
+  {"img",    "src",  LinkRelated},
+  {"script", "src",  LinkRelated},
+  {"iframe", "src",  LinkRelated},
+}
+
+var css_url_pattern = regexp.MustCompile(`url\(\s*['"]?([^'")]+)['"]?\s*\)`)
+var css_import_pattern = regexp.MustCompile(`@import\s+['"]([^'"]+)['"]`)
+
+type extracted_link struct {
+  url  string
+  kind LinkKind
+}
+
+// extract_css_urls pulls every url(...) and bare @import "..." reference
+// out of a chunk of CSS (a <style> body or a style="" attribute).
+func extract_css_urls (css string) (links [] extracted_link) {
+  for _, m := range css_url_pattern.FindAllStringSubmatch(css, -1) {
+    links = append(links, extracted_link{m[1], LinkRelated})
+  }
+  for _, m := range css_import_pattern.FindAllStringSubmatch(css, -1) {
+    links = append(links, extracted_link{m[1], LinkRelated})
+  }
+  return
+}
+
+// extract_links tokenizes an HTML document and returns every link named
+// by link_rules, plus anything reachable via @import/url(...) in <style>
+// elements and style="" attributes.
+func extract_links (body [] byte) (links [] extracted_link) {
+  tokenizer := html.NewTokenizer(bytes.NewReader(body))
+  in_style := false
+
+  for {
+    tt := tokenizer.Next()
+
+    switch tt {
+    case html.ErrorToken:
+      return
+
+    case html.TextToken:
+      if in_style {
+        links = append(links, extract_css_urls(string(tokenizer.Text()))...)
+      }
+
+    case html.StartTagToken, html.SelfClosingTagToken:
+      token := tokenizer.Token()
+      tag := strings.ToLower(token.Data)
+
+      if tag == "style" && tt == html.StartTagToken {
+        in_style = true
+      }
+
+      for _, rule := range link_rules {
+        if rule.Tag != tag { continue }
+        for _, attr := range token.Attr {
+          if attr.Key == rule.Attr {
+            links = append(links, extracted_link{attr.Val, rule.Kind})
+          }
+        }
+      }
+
+      for _, attr := range token.Attr {
+        if attr.Key == "style" {
+          links = append(links, extract_css_urls(attr.Val)...)
+        }
+      }
+
+    case html.EndTagToken:
+      token := tokenizer.Token()
+      if strings.ToLower(token.Data) == "style" {
+        in_style = false
+      }
+    }
+  }
+}
@@ -12,26 +12,53 @@ import "strings"
 import "path/filepath"
 import "os"
 import "encoding/json"
+import "time"
+import "sync/atomic"
 
-import pqueue "github.com/nu7hatch/gopqueue"
+import "butler/robots"
+import "butler/sources"
+import "butler/visitqueue"
+
+const checkpoint_every_n_enqueues = 1000
 
 type Crawler struct {
   www       bool
   domains   map[string]bool
-  queue   * pqueue.Queue
-  waiter    sync.WaitGroup
-  known     map[string]bool
+  queue   * visitqueue.Queue
+  seen    * visitqueue.SeenSet
+  resuming  bool
 
   report_dir string
   reporters  []Reporter
-}
 
-type task struct {
-  url * url.URL
-}
-
-func (t * task) Less (other interface{}) (bool) {
-  return (len(t.url.String()) < len(other.(*task).url.String()))
+  user_agent        string
+  robot_rules        map[string] * robots.Rules
+  crawl_delay         time.Duration
+  last_fetch         map[string]time.Time
+  last_fetch_mutex    sync.Mutex
+
+  follow_related bool
+  enqueue_count  int64
+
+  http_client  * http.Client
+  retry_count    int
+  retry_backoff  time.Duration
+
+  dashboard_stats * StatsReporter
+  pool_size          int
+  pending_count      int64
+  active_workers     int64
+  active             int32
+  active_mutex       sync.RWMutex
+  paused             bool
+  paused_mutex       sync.RWMutex
+
+  include_patterns [] * regexp.Regexp
+  exclude_patterns [] * regexp.Regexp
+  max_depth          int
+  max_pages_per_host int
+  path_prefixes      map[string] [] string
+  host_page_counts * host_counters
 }
 
 func New(report_dir string)(c * Crawler, err error){
@@ -41,12 +68,16 @@ func New(report_dir string)(c * Crawler, err error){
   report_dir = filepath.Clean(report_dir)
 
   c = &Crawler{
-    report_dir : report_dir,
-    domains    : make(map[string]bool),
-    queue      : pqueue.New(0),
-    known      : make(map[string]bool),
-    reporters  : make([]Reporter, 0),
+    report_dir      : report_dir,
+    domains         : make(map[string]bool),
+    reporters       : make([]Reporter, 0),
+    robot_rules     : make(map[string] * robots.Rules),
+    last_fetch      : make(map[string]time.Time),
+    crawl_delay     : 0,
+    dashboard_stats : NewStatsReporter(),
+    host_page_counts: new_host_counters(),
   }
+  c.RegisterReporter(c.dashboard_stats)
   return
 }
 
@@ -54,9 +85,9 @@ func (c * Crawler) RegisterReporter (reporter Reporter) {
   c.reporters = append(c.reporters, reporter)
 }
 
-func (c * Crawler) report_success (u * url.URL, status uint) {
+func (c * Crawler) report_success (u * url.URL, status uint, kind LinkKind) {
   for _, reporter := range c.reporters {
-    reporter.Success(u, status)
+    reporter.Success(u, status, kind)
   }
 }
 
@@ -76,7 +107,7 @@ func (c * Crawler) allow (domain string) {
   c.domains[domain] = true
 }
 
-func (c * Crawler) enqueue (link * url.URL, base * url.URL) {
+func (c * Crawler) enqueue (link * url.URL, base * url.URL, kind LinkKind, depth int) {
   if base != nil {
     link = base.ResolveReference(link)
     link.Fragment = ""
@@ -92,16 +123,27 @@ func (c * Crawler) enqueue (link * url.URL, base * url.URL) {
     link.Host = c.normalize_host(link.Host)
   }
 
-  if _, present := c.known[link.String()]; present {
+  if !c.seen.Add(link.String()) {
     return
   }
 
-  c.known[link.String()] = true
-
-  if link.Scheme == "http" {
+  if link.Scheme == "http" || link.Scheme == "https" {
     if c.domains[link.Host] {
-      c.waiter.Add(1)
-      c.queue.Enqueue(&task{url: link})
+      if !c.robots_allow(link) {
+        c.report_ignored(link, 0, "robots.txt")
+        return
+      }
+      if kind == LinkRelated && !c.follow_related {
+        c.report_ignored(link, 0, "related asset")
+        return
+      }
+      if check := c.check_scope(link, depth); !check.allowed {
+        c.report_ignored(link, 0, check.reason)
+        return
+      }
+      atomic.AddInt64(&c.pending_count, 1)
+      c.queue.Enqueue(visitqueue.Record{URL: link.String(), Kind: int(kind), Depth: depth})
+      c.checkpoint()
       return
     } else {
       c.report_ignored(link, 0, "external domain")
@@ -111,15 +153,154 @@ func (c * Crawler) enqueue (link * url.URL, base * url.URL) {
     c.report_ignored(link, 0, "wrong scheme: "+link.Scheme)
     return
   }
+}
+
+// robots_allow consults the cached robots.txt rules for link's host, if
+// any were fetched, and reports whether link's path may be crawled.
+func (c * Crawler) robots_allow (link * url.URL) (bool) {
+  rules, present := c.robot_rules[link.Host]
+  if !present {
+    return true
+  }
+  return rules.Allowed(link.Path)
+}
+
+// fetch_robots downloads and caches domain's robots.txt, seeding any
+// Sitemap URLs it declares into the queue. A missing or unparsable
+// robots.txt is treated as "allow everything".
+func (c * Crawler) fetch_robots (domain string) {
+  u, err := url.Parse("http://" + domain + "/robots.txt")
+  if err != nil { return }
+
+  resp, err := c.do_get(u)
+  if err != nil { return }
+  defer resp.Body.Close()
+
+  if resp.StatusCode != 200 {
+    return
+  }
+
+  body, err := ioutil.ReadAll(resp.Body)
+  if err != nil { return }
+
+  rules := robots.Parse(body, c.user_agent)
+  c.robot_rules[domain] = rules
+
+  for _, sitemap := range rules.Sitemaps {
+    su, err := url.Parse(sitemap)
+    if err != nil { continue }
+    c.enqueue(su, nil, LinkPrimary, 0)
+  }
+}
+
+// wait_for_crawl_delay blocks until it is polite to issue another request
+// to host, honouring that host's robots.txt Crawl-delay (falling back to
+// c.crawl_delay) before letting a worker proceed.
+func (c * Crawler) wait_for_crawl_delay (host string) {
+  delay := c.crawl_delay
+  if rules, present := c.robot_rules[host]; present {
+    if d, ok := rules.CrawlDelay(); ok {
+      delay = d
+    }
+  }
+  if delay <= 0 {
+    return
+  }
+
+  c.last_fetch_mutex.Lock()
+  last, seen := c.last_fetch[host]
+  wait := time.Duration(0)
+  if seen {
+    elapsed := time.Since(last)
+    if elapsed < delay {
+      wait = delay - elapsed
+    }
+  }
+  c.last_fetch[host] = time.Now().Add(wait)
+  c.last_fetch_mutex.Unlock()
+
+  if wait > 0 {
+    time.Sleep(wait)
+  }
+}
+
+// checkpoint persists the visit queue's acked position and the seen-set's
+// bloom filter every checkpoint_every_n_enqueues enqueues, so a crawl
+// interrupted mid-run can pick back up close to where it left off.
+func (c * Crawler) checkpoint () {
+  if atomic.AddInt64(&c.enqueue_count, 1) % checkpoint_every_n_enqueues != 0 {
+    return
+  }
+  c.queue.Checkpoint()
+  c.seen.Flush()
+}
+
+// is_paused reports whether workers should hold off on dequeuing.
+func (c * Crawler) is_paused () (bool) {
+  c.paused_mutex.RLock()
+  defer c.paused_mutex.RUnlock()
+  return c.paused
+}
+
+// set_paused flips the pause flag the dashboard's /api/pause and
+// /api/resume endpoints expose.
+func (c * Crawler) set_paused (paused bool) {
+  c.paused_mutex.Lock()
+  c.paused = paused
+  c.paused_mutex.Unlock()
+}
+
+// is_active reports whether a crawl is currently running.
+func (c * Crawler) is_active () (bool) {
+  return atomic.LoadInt32(&c.active) != 0
+}
+
+// enqueue_if_active is what runtime enqueues (currently just the
+// dashboard's /api/enqueue) must go through instead of calling enqueue
+// directly. It holds active_mutex for read for the duration of the
+// enqueue, which Run's termination check takes for write before
+// committing to stop, so a runtime enqueue either lands before shutdown
+// commits or is rejected outright - it can never land in the gap where
+// Run has observed pending_count == 0 but not yet flipped c.active.
+func (c * Crawler) enqueue_if_active (link * url.URL, base * url.URL, kind LinkKind, depth int) (bool) {
+  c.active_mutex.RLock()
+  defer c.active_mutex.RUnlock()
+
+  if !c.is_active() {
+    return false
+  }
+  c.enqueue(link, base, kind, depth)
+  return true
+}
 
-  /*c.waiter.Add(1)*/
-  /*c.queue <- u.String()*/
+// seed_resume_counters makes pending_count reflect the records the
+// on-disk queue already holds but hasn't acked, for a resumed crawl.
+// Resume reopens the queue and feed_loop will redeliver exactly these
+// records (see Queue.PendingCount), but nothing else ever counts them
+// in - without this, Run's termination check sees pending_count == 0
+// before a single one of them has been processed and shuts down
+// immediately, or a record the feeder does deliver drives pending_count
+// negative once it's acked.
+func (c * Crawler) seed_resume_counters () {
+  pending, err := c.queue.PendingCount()
+  if err != nil { return }
+  atomic.StoreInt64(&c.pending_count, pending)
 }
 
 func (c * Crawler) Run (pool_size int) {
-  os.RemoveAll(c.report_dir)
   os.MkdirAll(c.report_dir, 0755)
 
+  c.pool_size = pool_size
+  atomic.StoreInt32(&c.active, 1)
+
+  if c.resuming {
+    c.seed_resume_counters()
+  }
+
+  if *dashboard_addr != "" {
+    c.start_dashboard(*dashboard_addr)
+  }
+
   for _, reporter := range c.reporters {
     reporter.Start()
   }
@@ -127,25 +308,76 @@ func (c * Crawler) Run (pool_size int) {
   for i := 0; i <= pool_size; i ++ {
     go func(){
       for{
-        t := c.queue.Dequeue()
-        c.process_url(t.(*task).url)
-        c.waiter.Done()
+        for c.is_paused() {
+          time.Sleep(100 * time.Millisecond)
+        }
+
+        rec, line, ok := c.queue.Dequeue()
+        if !ok { return }
+
+        atomic.AddInt64(&c.active_workers, 1)
+
+        u, err := url.Parse(rec.URL)
+        if err == nil {
+          c.process_url(u, LinkKind(rec.Kind), rec.Depth)
+        }
+
+        atomic.AddInt64(&c.active_workers, -1)
+        atomic.AddInt64(&c.pending_count, -1)
+        c.queue.Ack(line)
       }
     }()
   }
 
-  c.waiter.Wait()
+  // Polling pending_count (rather than blocking on a sync.WaitGroup) is
+  // what lets this coexist with enqueue_if_active: a plain atomic load
+  // can never panic on "reused before previous Wait returned" the way a
+  // WaitGroup can when an Add races a Wait that's observing zero. The
+  // lock only has to guard the commit itself - enqueue_if_active holds
+  // active_mutex for read across its whole pending_count increment, so
+  // by the time this goroutine gets the write lock, any enqueue that was
+  // in flight has already landed and will be seen below.
+  for {
+    if atomic.LoadInt64(&c.pending_count) != 0 {
+      time.Sleep(100 * time.Millisecond)
+      continue
+    }
+
+    c.active_mutex.Lock()
+    if atomic.LoadInt64(&c.pending_count) == 0 {
+      atomic.StoreInt32(&c.active, 0)
+      c.active_mutex.Unlock()
+      break
+    }
+    c.active_mutex.Unlock()
+  }
+
+  // Unblocks every worker's Dequeue so they return instead of leaking
+  // forever on an empty queue now that no more work can arrive.
+  c.queue.Stop()
+
+  c.queue.Checkpoint()
+  c.seen.Flush()
 
   for _, reporter := range c.reporters {
     reporter.Finish(c.report_dir)
   }
 }
 
-var pattern * regexp.Regexp
+func (c * Crawler) process_url (page * url.URL, kind LinkKind, depth int) {
+  c.wait_for_crawl_delay(page.Host)
 
-func (c * Crawler) process_url (page * url.URL) {
-  resp, err := http.Get(page.String())
+  resp, err := c.do_get(page)
   if err != nil {
+    if is_redirect_blocked(err) && resp != nil {
+      location := resp.Header.Get("Location")
+      c.report_ignored(page, uint(resp.StatusCode), fmt.Sprintf("redirect: %d -> %s", resp.StatusCode, location))
+
+      if dest, parse_err := url.Parse(location); parse_err == nil {
+        c.enqueue(dest, page, kind, depth)
+      }
+      return
+    }
     c.report_error(page, 0, err)
     return
   }
@@ -165,11 +397,8 @@ func (c * Crawler) process_url (page * url.URL) {
     return
   }
 
-  links := pattern.FindAllStringSubmatch(string(body), -1)
-  for _, m := range links {
-    link := m[1]
-
-    link = html.UnescapeString(link)
+  for _, found := range extract_links(body) {
+    link := html.UnescapeString(found.url)
 
     if strings.HasPrefix(link, "#") {
       continue
@@ -178,10 +407,10 @@ func (c * Crawler) process_url (page * url.URL) {
     u, err := url.Parse(link)
     if err != nil { fmt.Printf("Invalid url: %s\n", link); continue }
 
-    c.enqueue(u, page)
+    c.enqueue(u, page, found.kind, depth+1)
   }
 
-  c.report_success(page, uint(resp.StatusCode))
+  c.report_success(page, uint(resp.StatusCode), kind)
 }
 
 func (c * Crawler) normalize_host (host string) (string) {
@@ -201,44 +430,192 @@ func (c * Crawler) normalize_host (host string) (string) {
   return ""
 }
 
+// Load starts a fresh crawl from config: it wipes any previous report
+// directory, opens a new on-disk visit queue and seen-set, and seeds the
+// queue with each configured domain's root (and robots.txt sitemaps).
+// Callers should check visitqueue.Existing(report_dir) first and call
+// Resume instead when a prior checkpoint is present.
 func (c * Crawler) Load (path string) (err error) {
-  var config   Config
-  var u      * url.URL
+  var u * url.URL
 
-  jsonBlob, err := ioutil.ReadFile(path)
+  config, err := read_config(path)
   if err != nil { return }
 
-  err = json.Unmarshal(jsonBlob, &config)
+  err = c.configure(config)
   if err != nil { return }
 
-  c.www = config.Www
+  os.RemoveAll(c.report_dir)
+  os.MkdirAll(c.report_dir, 0755)
+
+  c.queue, err = visitqueue.Open(c.report_dir)
+  if err != nil { return }
+
+  c.seen, err = visitqueue.OpenSeenSet(filepath.Join(c.report_dir, "seen.bloom"))
+  if err != nil { return }
+
+  source_names := config.Sources
+  if *other_source != "" {
+    source_names = append(source_names, strings.Split(*other_source, ",")...)
+  }
 
   for _, domain := range config.Domains {
     domain = c.normalize_host(domain)
     c.allow(domain)
+    c.fetch_robots(domain)
 
     u, err = url.Parse("http://"+domain+"/")
     if err != nil { return }
 
-    c.enqueue(u, nil)
+    c.enqueue(u, nil, LinkPrimary, 0)
+
+    c.seed_from_sources(domain, source_names, config.IncludeSubdomains)
   }
 
   return
 }
 
-type Config struct {
-  Www     bool     `json:"www"`
-  Domains []string `json:"domains"`
+// seed_from_sources enqueues every URL the named third-party sources
+// (see the sources package) have on record for domain. When
+// include_subdomains is set, any subdomain turned up this way is added
+// to the allowed set rather than discarded as "external domain".
+func (c * Crawler) seed_from_sources (domain string, names [] string, include_subdomains bool) {
+  for _, name := range names {
+    name = strings.TrimSpace(name)
+    source, present := sources.Get(name)
+    if !present { continue }
+
+    found, err := source.Discover(domain)
+    if err != nil { continue }
+
+    for _, u := range found {
+      host := c.normalize_host(u.Host)
+
+      if include_subdomains && strings.HasSuffix(host, "."+domain) {
+        c.allow(host)
+      }
+
+      c.enqueue(u, nil, LinkPrimary, 0)
+    }
+  }
 }
 
-func init() {
-  var err error
-  pattern, err = regexp.Compile("[<]a[^>]+href[=][\"']([^\"']+)[\"']")
-  if err != nil { panic(err) }
+// Resume picks a crawl back up from a previous checkpoint under path,
+// reopening the durable visit queue and seen-set without wiping or
+// re-seeding anything. If path turns out to hold no checkpoint after
+// all (an empty queue file), this falls back to Load's normal seed
+// behavior using the configured --config file.
+func (c * Crawler) Resume (path string) (err error) {
+  path, err = filepath.Abs(path)
+  if err != nil { return }
+  path = filepath.Clean(path)
+
+  if !visitqueue.Existing(path) {
+    return c.Load(*config_file)
+  }
+
+  config, err := read_config(*config_file)
+  if err != nil { return }
+
+  err = c.configure(config)
+  if err != nil { return }
+
+  for _, domain := range config.Domains {
+    c.allow(c.normalize_host(domain))
+  }
+
+  c.report_dir = path
+  c.resuming = true
+
+  c.queue, err = visitqueue.Open(path)
+  if err != nil { return }
+
+  c.seen, err = visitqueue.OpenSeenSet(filepath.Join(path, "seen.bloom"))
+  if err != nil { return }
+
+  return
+}
+
+// read_config loads and parses the JSON config file at path.
+func read_config (path string) (config Config, err error) {
+  jsonBlob, err := ioutil.ReadFile(path)
+  if err != nil { return }
+
+  err = json.Unmarshal(jsonBlob, &config)
+  return
 }
 
-var config_file = flag.String("config", "config.json", "The path to the config file.")
-var report_dir  = flag.String("report", "report",      "The path to the report directory.")
+// configure applies config's non-seeding settings (politeness, transport,
+// user agent) to c. Both Load and Resume call this so a resumed crawl
+// honours the same config a fresh one would.
+func (c * Crawler) configure (config Config) (err error) {
+  c.www = config.Www
+  c.user_agent = config.UserAgent
+  if c.user_agent == "" {
+    c.user_agent = "butler"
+  }
+  c.crawl_delay = time.Duration(config.CrawlDelay * float64(time.Second))
+  c.follow_related = config.FollowRelated
+  c.retry_count = config.RetryCount
+  c.retry_backoff = seconds(config.RetryBackoff, 1*time.Second)
+
+  c.max_depth = config.MaxDepth
+  c.max_pages_per_host = config.MaxPagesPerHost
+  c.path_prefixes = config.PathPrefixes
+
+  c.include_patterns, err = compile_patterns(config.IncludePatterns)
+  if err != nil { return }
+
+  c.exclude_patterns, err = compile_patterns(config.ExcludePatterns)
+  if err != nil { return }
+
+  c.http_client, err = build_http_client(config)
+  return
+}
+
+// compile_patterns compiles each of patterns, failing on the first
+// invalid one so a typo in Config surfaces immediately rather than
+// silently matching nothing.
+func compile_patterns (patterns [] string) (compiled [] * regexp.Regexp, err error) {
+  for _, pattern := range patterns {
+    re, compile_err := regexp.Compile(pattern)
+    if compile_err != nil { return nil, compile_err }
+    compiled = append(compiled, re)
+  }
+  return
+}
+
+type Config struct {
+  Www               bool     `json:"www"`
+  Domains           []string `json:"domains"`
+  UserAgent         string   `json:"user_agent"`
+  CrawlDelay        float64  `json:"crawl_delay"`
+  FollowRelated     bool     `json:"follow_related"`
+  Sources           []string `json:"sources"`
+  IncludeSubdomains bool     `json:"include_subdomains"`
+
+  RequestTimeout      float64 `json:"request_timeout"`
+  DialTimeout         float64 `json:"dial_timeout"`
+  TLSHandshakeTimeout float64 `json:"tls_handshake_timeout"`
+  IdleConnTimeout     float64 `json:"idle_conn_timeout"`
+  MaxIdleConns        int     `json:"max_idle_conns"`
+  Proxy               string  `json:"proxy"`
+  InsecureSkipVerify  bool    `json:"insecure_skip_verify"`
+  FollowRedirects     bool    `json:"follow_redirects"`
+  MaxRedirects        int     `json:"max_redirects"`
+  RetryCount          int     `json:"retry_count"`
+  RetryBackoff        float64 `json:"retry_backoff"`
+
+  IncludePatterns []string            `json:"include_patterns"`
+  ExcludePatterns []string            `json:"exclude_patterns"`
+  MaxDepth        int                 `json:"max_depth"`
+  MaxPagesPerHost int                 `json:"max_pages_per_host"`
+  PathPrefixes    map[string][]string `json:"path_prefixes"`
+}
+
+var config_file     = flag.String("config", "config.json", "The path to the config file.")
+var report_dir      = flag.String("report", "report",      "The path to the report directory.")
+var other_source    = flag.String("other-source", "",      "Comma-separated third-party URL sources to seed from in addition to the config file (wayback, commoncrawl).")
+var dashboard_addr  = flag.String("dashboard", "",          "Address to serve the live dashboard on, e.g. :8080 (disabled if empty).")
 
 func main() {
   flag.Parse()
@@ -250,7 +627,11 @@ func main() {
   c.RegisterReporter(new(ErrorReporter))
   c.RegisterReporter(new(IgnoreReporter))
 
-  err = c.Load(*config_file)
+  if visitqueue.Existing(*report_dir) {
+    err = c.Resume(*report_dir)
+  } else {
+    err = c.Load(*config_file)
+  }
   if err != nil { panic(err) }
   c.Run(2)
 }
@@ -0,0 +1,160 @@
+package main
+
+import "bytes"
+import "fmt"
+import "net/url"
+import "os"
+import "path/filepath"
+import "sync"
+
+// Reporter is notified of every crawl outcome as it happens. A Crawler
+// can have several registered at once (see RegisterReporter) - stats,
+// a sitemap, a stdout progress line and an error/ignore log all watch
+// the same stream independently.
+type Reporter interface {
+  Start ()
+  Success (u * url.URL, status uint, kind LinkKind)
+  Ignored (u * url.URL, status uint, reason interface{})
+  Error (u * url.URL, status uint, reason interface{})
+  Finish (report_dir string)
+}
+
+// SitemapReporter collects every successfully fetched primary page (not
+// related assets, which don't belong in a sitemap) and writes them out
+// as a flat list once the crawl finishes.
+type SitemapReporter struct {
+  mu   sync.Mutex
+  urls [] string
+}
+
+func (r * SitemapReporter) Start () {
+  r.urls = make([] string, 0)
+}
+
+func (r * SitemapReporter) Success (u * url.URL, status uint, kind LinkKind) {
+  if kind != LinkPrimary { return }
+
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  r.urls = append(r.urls, u.String())
+}
+
+func (r * SitemapReporter) Ignored (u * url.URL, status uint, reason interface{}) {}
+func (r * SitemapReporter) Error (u * url.URL, status uint, reason interface{}) {}
+
+func (r * SitemapReporter) Finish (report_dir string) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  var buf bytes.Buffer
+  for _, u := range r.urls {
+    buf.WriteString(u)
+    buf.WriteString("\n")
+  }
+
+  os.WriteFile(filepath.Join(report_dir, "sitemap.txt"), buf.Bytes(), 0644)
+}
+
+// StdoutReporter prints a one-line progress message per outcome, for a
+// crawl run interactively without --dashboard.
+type StdoutReporter struct{}
+
+func (r * StdoutReporter) Start () {
+  fmt.Println("butler: crawl starting")
+}
+
+func (r * StdoutReporter) Success (u * url.URL, status uint, kind LinkKind) {
+  fmt.Printf("OK    %3d %s\n", status, u.String())
+}
+
+func (r * StdoutReporter) Ignored (u * url.URL, status uint, reason interface{}) {
+  fmt.Printf("SKIP      %s (%v)\n", u.String(), reason)
+}
+
+func (r * StdoutReporter) Error (u * url.URL, status uint, reason interface{}) {
+  fmt.Printf("ERROR %3d %s (%v)\n", status, u.String(), reason)
+}
+
+func (r * StdoutReporter) Finish (report_dir string) {
+  fmt.Println("butler: crawl finished")
+}
+
+// error_entry is one failed fetch, as ErrorReporter will log it.
+type error_entry struct {
+  url    string
+  status uint
+  reason string
+}
+
+// ErrorReporter collects every failed fetch and writes them to
+// errors.log under the report directory once the crawl finishes.
+type ErrorReporter struct {
+  mu      sync.Mutex
+  entries [] error_entry
+}
+
+func (r * ErrorReporter) Start () {
+  r.entries = make([] error_entry, 0)
+}
+
+func (r * ErrorReporter) Success (u * url.URL, status uint, kind LinkKind) {}
+func (r * ErrorReporter) Ignored (u * url.URL, status uint, reason interface{}) {}
+
+func (r * ErrorReporter) Error (u * url.URL, status uint, reason interface{}) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  r.entries = append(r.entries, error_entry{url: u.String(), status: status, reason: fmt.Sprintf("%v", reason)})
+}
+
+func (r * ErrorReporter) Finish (report_dir string) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  var buf bytes.Buffer
+  for _, e := range r.entries {
+    buf.WriteString(fmt.Sprintf("%d %s %s\n", e.status, e.url, e.reason))
+  }
+
+  os.WriteFile(filepath.Join(report_dir, "errors.log"), buf.Bytes(), 0644)
+}
+
+// ignore_entry is one skipped link, as IgnoreReporter will log it.
+type ignore_entry struct {
+  url    string
+  reason string
+}
+
+// IgnoreReporter collects every link that was deliberately skipped (out
+// of scope, blocked by robots.txt, wrong content type, ...) and writes
+// them to ignored.log under the report directory once the crawl
+// finishes, so an operator can tell "never fetched" apart from "fetched
+// and failed".
+type IgnoreReporter struct {
+  mu      sync.Mutex
+  entries [] ignore_entry
+}
+
+func (r * IgnoreReporter) Start () {
+  r.entries = make([] ignore_entry, 0)
+}
+
+func (r * IgnoreReporter) Success (u * url.URL, status uint, kind LinkKind) {}
+func (r * IgnoreReporter) Error (u * url.URL, status uint, reason interface{}) {}
+
+func (r * IgnoreReporter) Ignored (u * url.URL, status uint, reason interface{}) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  r.entries = append(r.entries, ignore_entry{url: u.String(), reason: fmt.Sprintf("%v", reason)})
+}
+
+func (r * IgnoreReporter) Finish (report_dir string) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  var buf bytes.Buffer
+  for _, e := range r.entries {
+    buf.WriteString(fmt.Sprintf("%s %s\n", e.url, e.reason))
+  }
+
+  os.WriteFile(filepath.Join(report_dir, "ignored.log"), buf.Bytes(), 0644)
+}
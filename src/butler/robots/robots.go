@@ -0,0 +1,167 @@
+// Package robots implements a small robots.txt parser: just enough to pick
+// the most specific User-agent group for a crawler and answer Allowed/
+// Disallowed questions, plus surface Sitemap and Crawl-delay directives.
+package robots
+
+import "bufio"
+import "strconv"
+import "strings"
+import "time"
+
+type group struct {
+  agent      string
+  allow    []string
+  disallow []string
+  delay      time.Duration
+}
+
+type Rules struct {
+  groups   []*group
+  Sitemaps []string
+}
+
+func Parse (body [] byte, userAgent string) (r * Rules) {
+  r = &Rules{groups: make([]*group, 0), Sitemaps: make([]string, 0)}
+
+  var current * group
+  scanner := bufio.NewScanner(strings.NewReader(string(body)))
+
+  for scanner.Scan() {
+    line := scanner.Text()
+
+    if idx := strings.Index(line, "#"); idx != -1 {
+      line = line[:idx]
+    }
+    line = strings.TrimSpace(line)
+    if line == "" {
+      continue
+    }
+
+    parts := strings.SplitN(line, ":", 2)
+    if len(parts) != 2 {
+      continue
+    }
+
+    field := strings.ToLower(strings.TrimSpace(parts[0]))
+    value := strings.TrimSpace(parts[1])
+
+    switch field {
+    case "user-agent":
+      current = &group{agent: strings.ToLower(value)}
+      r.groups = append(r.groups, current)
+    case "disallow":
+      if current != nil && value != "" {
+        current.disallow = append(current.disallow, value)
+      }
+    case "allow":
+      if current != nil && value != "" {
+        current.allow = append(current.allow, value)
+      }
+    case "crawl-delay":
+      if current != nil {
+        if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+          current.delay = time.Duration(seconds * float64(time.Second))
+        }
+      }
+    case "sitemap":
+      r.Sitemaps = append(r.Sitemaps, value)
+    }
+  }
+
+  r.selectFor(userAgent)
+  return
+}
+
+// selectFor narrows r.groups down to the single most specific one that
+// applies to userAgent: the matching group whose agent token is longest,
+// falling back to "*" when nothing more specific matches. Per the
+// robots.txt spec only one group ever applies to a given user agent, so
+// overlapping tokens (e.g. "but" and "butler") must not both contribute
+// rules.
+func (r * Rules) selectFor (userAgent string) {
+  userAgent = strings.ToLower(userAgent)
+
+  var best * group
+  for _, g := range r.groups {
+    if g.agent == "" || g.agent == "*" { continue }
+    if !strings.Contains(userAgent, g.agent) { continue }
+    if best == nil || len(g.agent) > len(best.agent) {
+      best = g
+    }
+  }
+
+  if best == nil {
+    for _, g := range r.groups {
+      if g.agent == "*" {
+        best = g
+        break
+      }
+    }
+  }
+
+  var matched [] * group
+  if best != nil {
+    matched = append(matched, best)
+  }
+
+  r.groups = matched
+}
+
+// Allowed reports whether path may be fetched, picking the longest matching
+// Allow/Disallow rule across the selected groups (longest-match wins, ties
+// favour Allow, per the de-facto robots.txt convention).
+func (r * Rules) Allowed (path string) (bool) {
+  best := -1
+  allowed := true
+
+  consider := func (rule string, isAllow bool) {
+    if rule == "" {
+      if !isAllow {
+        // an empty Disallow means "allow everything"
+        if 0 > best {
+          best = 0
+          allowed = true
+        }
+      }
+      return
+    }
+    if !strings.HasPrefix(path, rule) {
+      return
+    }
+    // Allow uses >= so that a tie against an equal-length Disallow
+    // still resolves in Allow's favour, matching the doc comment above.
+    if isAllow {
+      if len(rule) >= best {
+        best = len(rule)
+        allowed = true
+      }
+    } else {
+      if len(rule) > best {
+        best = len(rule)
+        allowed = false
+      }
+    }
+  }
+
+  for _, g := range r.groups {
+    for _, rule := range g.disallow {
+      consider(rule, false)
+    }
+    for _, rule := range g.allow {
+      consider(rule, true)
+    }
+  }
+
+  return allowed
+}
+
+// CrawlDelay returns the most specific matching group's Crawl-delay, and
+// whether one was specified at all.
+func (r * Rules) CrawlDelay () (time.Duration, bool) {
+  for _, g := range r.groups {
+    if g.delay > 0 {
+      return g.delay, true
+    }
+  }
+  return 0, false
+}
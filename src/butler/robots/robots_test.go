@@ -0,0 +1,72 @@
+package robots
+
+import "testing"
+
+func TestSelectForPicksMostSpecificGroup (t * testing.T) {
+  body := []byte(`
+User-agent: *
+Disallow: /private
+
+User-agent: but
+Disallow: /
+
+User-agent: butler
+Disallow:
+`)
+
+  rules := Parse(body, "butler/1.0")
+
+  if !rules.Allowed("/private") {
+    t.Fatalf("expected the butler group (no Disallow rules) to win over both the but and * groups, got /private blocked")
+  }
+}
+
+func TestSelectForFallsBackToWildcard (t * testing.T) {
+  body := []byte(`
+User-agent: *
+Disallow: /private
+
+User-agent: someoneelse
+Disallow: /
+`)
+
+  rules := Parse(body, "butler/1.0")
+
+  if rules.Allowed("/private") {
+    t.Fatalf("expected the wildcard group's Disallow to apply when nothing more specific matches")
+  }
+  if !rules.Allowed("/public") {
+    t.Fatalf("expected paths outside the wildcard group's rules to be allowed")
+  }
+}
+
+func TestAllowedTiesFavourAllow (t * testing.T) {
+  body := []byte(`
+User-agent: *
+Disallow: /x
+Allow: /x
+`)
+
+  rules := Parse(body, "butler/1.0")
+
+  if !rules.Allowed("/x") {
+    t.Fatalf("expected an equal-length Allow to override Disallow on a tie")
+  }
+}
+
+func TestAllowedLongestRuleWins (t * testing.T) {
+  body := []byte(`
+User-agent: *
+Allow: /x
+Disallow: /x/secret
+`)
+
+  rules := Parse(body, "butler/1.0")
+
+  if !rules.Allowed("/x/public") {
+    t.Fatalf("expected /x/public to be allowed by the shorter Allow rule")
+  }
+  if rules.Allowed("/x/secret") {
+    t.Fatalf("expected /x/secret to be blocked by the longer, more specific Disallow rule")
+  }
+}
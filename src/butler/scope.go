@@ -0,0 +1,94 @@
+package main
+
+import "fmt"
+import "net/url"
+import "strings"
+import "sync"
+
+// scope_check is the outcome of evaluating a link against the scope
+// rules below: either it's in scope, or it isn't, with a reason a
+// reporter can surface to the operator.
+type scope_check struct {
+  allowed bool
+  reason  string
+}
+
+func in_scope () (scope_check) { return scope_check{allowed: true} }
+func out_of_scope (reason string) (scope_check) { return scope_check{allowed: false, reason: reason} }
+
+// host_counters tracks how many pages have been admitted per host, so
+// MaxPagesPerHost can be enforced without a TOCTOU race between workers.
+type host_counters struct {
+  mu     sync.Mutex
+  counts map[string]int64
+}
+
+func new_host_counters () (* host_counters) {
+  return &host_counters{counts: make(map[string]int64)}
+}
+
+// admit increments host's counter and reports whether it was still under
+// limit (0 meaning unlimited) before this increment.
+func (h * host_counters) admit (host string, limit int) (bool) {
+  if limit <= 0 {
+    return true
+  }
+
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  if h.counts[host] >= int64(limit) {
+    return false
+  }
+  h.counts[host] ++
+  return true
+}
+
+// check_scope evaluates link (already resolved and normalized) against
+// c's configured Include/ExcludePatterns, MaxDepth, PathPrefixes and
+// MaxPagesPerHost, in that order (exclude wins over include).
+func (c * Crawler) check_scope (link * url.URL, depth int) (scope_check) {
+  target := link.String()
+
+  for _, pattern := range c.exclude_patterns {
+    if pattern.MatchString(target) {
+      return out_of_scope(fmt.Sprintf("excluded: %s", pattern.String()))
+    }
+  }
+
+  if len(c.include_patterns) > 0 {
+    included := false
+    for _, pattern := range c.include_patterns {
+      if pattern.MatchString(target) {
+        included = true
+        break
+      }
+    }
+    if !included {
+      return out_of_scope("not included")
+    }
+  }
+
+  if c.max_depth > 0 && depth > c.max_depth {
+    return out_of_scope("max-depth")
+  }
+
+  if prefixes, present := c.path_prefixes[link.Host]; present {
+    matched := false
+    for _, prefix := range prefixes {
+      if strings.HasPrefix(link.Path, prefix) {
+        matched = true
+        break
+      }
+    }
+    if !matched {
+      return out_of_scope("outside path prefix")
+    }
+  }
+
+  if !c.host_page_counts.admit(link.Host, c.max_pages_per_host) {
+    return out_of_scope("max-pages-per-host")
+  }
+
+  return in_scope()
+}
@@ -0,0 +1,49 @@
+package sources
+
+import "bufio"
+import "bytes"
+import "encoding/json"
+import "fmt"
+import "io/ioutil"
+import "net/http"
+import "net/url"
+
+// CommonCrawl discovers URLs indexed by the commoncrawl.org crawl named
+// in commoncrawl_latest_index via its CDX-compatible index server.
+type CommonCrawl struct{}
+
+const commoncrawl_latest_index = "CC-MAIN-2024-10"
+
+type commoncrawl_record struct {
+  Url string `json:"url"`
+}
+
+func (cc CommonCrawl) Discover (domain string) (urls [] * url.URL, err error) {
+  endpoint := fmt.Sprintf(
+    "https://index.commoncrawl.org/%s-index?url=%s/*&output=json",
+    commoncrawl_latest_index, url.QueryEscape(domain))
+
+  resp, err := http.Get(endpoint)
+  if err != nil { return }
+  defer resp.Body.Close()
+
+  body, err := ioutil.ReadAll(resp.Body)
+  if err != nil { return }
+
+  // the index server replies with one JSON object per line, not a JSON
+  // array, so scan it line by line
+  scanner := bufio.NewScanner(bytes.NewReader(body))
+  for scanner.Scan() {
+    var rec commoncrawl_record
+    if json.Unmarshal(scanner.Bytes(), &rec) != nil || rec.Url == "" {
+      continue
+    }
+
+    u, parse_err := url.Parse(rec.Url)
+    if parse_err != nil { continue }
+
+    urls = append(urls, u)
+  }
+
+  return
+}
@@ -0,0 +1,24 @@
+// Package sources lets a crawl seed its frontier from third-party URL
+// archives (the Wayback Machine, Common Crawl) rather than only the URLs
+// reachable by following links from a single root, which is the single
+// biggest coverage win for a link-audit report.
+package sources
+
+import "net/url"
+
+// Source discovers previously-seen URLs for domain from some external
+// archive.
+type Source interface {
+  Discover (domain string) ([] * url.URL, error)
+}
+
+var registry = map[string]Source{
+  "wayback":     Wayback{},
+  "commoncrawl": CommonCrawl{},
+}
+
+// Get looks up a Source by its --other-source / Config.Sources name.
+func Get (name string) (s Source, present bool) {
+  s, present = registry[name]
+  return
+}
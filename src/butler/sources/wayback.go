@@ -0,0 +1,39 @@
+package sources
+
+import "encoding/json"
+import "fmt"
+import "io/ioutil"
+import "net/http"
+import "net/url"
+
+// Wayback discovers URLs the Internet Archive has ever crawled for a
+// domain via the CDX API.
+type Wayback struct{}
+
+func (w Wayback) Discover (domain string) (urls [] * url.URL, err error) {
+  endpoint := fmt.Sprintf(
+    "https://web.archive.org/cdx/search/cdx?url=%s/*&output=json&fl=original&collapse=urlkey",
+    url.QueryEscape(domain))
+
+  resp, err := http.Get(endpoint)
+  if err != nil { return }
+  defer resp.Body.Close()
+
+  body, err := ioutil.ReadAll(resp.Body)
+  if err != nil { return }
+
+  var rows [][]string
+  if err = json.Unmarshal(body, &rows); err != nil { return }
+
+  // the first row is the column header ("original"); skip it
+  for i, row := range rows {
+    if i == 0 || len(row) == 0 { continue }
+
+    u, parse_err := url.Parse(row[0])
+    if parse_err != nil { continue }
+
+    urls = append(urls, u)
+  }
+
+  return
+}
@@ -0,0 +1,73 @@
+package main
+
+import "net/url"
+import "sync"
+import "sync/atomic"
+
+// DomainStats tracks per-host progress for the live dashboard.
+type DomainStats struct {
+  Success int64 `json:"success"`
+  Errors  int64 `json:"errors"`
+  Ignored int64 `json:"ignored"`
+}
+
+// StatsReporter is a Reporter that only tallies counters, for the
+// dashboard's /api/stats and /api/domains endpoints to read back. It is
+// registered like any other reporter, so it sees exactly what the
+// sitemap/stdout/error/ignore reporters see.
+type StatsReporter struct {
+  success int64
+  errors  int64
+  ignored int64
+
+  mu      sync.Mutex
+  domains map[string] * DomainStats
+}
+
+func NewStatsReporter () (* StatsReporter) {
+  return &StatsReporter{domains: make(map[string] * DomainStats)}
+}
+
+func (s * StatsReporter) domain_stats (host string) (* DomainStats) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  d, present := s.domains[host]
+  if !present {
+    d = &DomainStats{}
+    s.domains[host] = d
+  }
+  return d
+}
+
+func (s * StatsReporter) Start () {}
+
+func (s * StatsReporter) Success (u * url.URL, status uint, kind LinkKind) {
+  atomic.AddInt64(&s.success, 1)
+  atomic.AddInt64(&s.domain_stats(u.Host).Success, 1)
+}
+
+func (s * StatsReporter) Ignored (u * url.URL, status uint, reason interface{}) {
+  atomic.AddInt64(&s.ignored, 1)
+  atomic.AddInt64(&s.domain_stats(u.Host).Ignored, 1)
+}
+
+func (s * StatsReporter) Error (u * url.URL, status uint, reason interface{}) {
+  atomic.AddInt64(&s.errors, 1)
+  atomic.AddInt64(&s.domain_stats(u.Host).Errors, 1)
+}
+
+func (s * StatsReporter) Finish (report_dir string) {}
+
+// Domains returns a snapshot of the per-domain counters, safe to
+// marshal to JSON.
+func (s * StatsReporter) Domains () (map[string] DomainStats) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  out := make(map[string]DomainStats, len(s.domains))
+  for host, d := range s.domains {
+    out[host] = *d
+  }
+  return out
+}
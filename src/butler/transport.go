@@ -0,0 +1,118 @@
+package main
+
+import "crypto/tls"
+import "errors"
+import "fmt"
+import "net"
+import "net/http"
+import "net/url"
+import "time"
+
+// err_redirect_blocked is returned by a Client's CheckRedirect when
+// Config.FollowRedirects is false, so the Client surfaces the redirect
+// response back to process_url instead of silently following it.
+var err_redirect_blocked = errors.New("redirect blocked by configuration")
+
+// build_http_client constructs the *http.Client a Crawler fetches pages
+// with, from Config's transport knobs: dial/handshake/idle timeouts, an
+// optional proxy, TLS verification, and a redirect policy.
+func build_http_client (config Config) (client * http.Client, err error) {
+  dialer := &net.Dialer{
+    Timeout: seconds(config.DialTimeout, 10*time.Second),
+  }
+
+  transport := &http.Transport{
+    DialContext:         dialer.DialContext,
+    TLSHandshakeTimeout: seconds(config.TLSHandshakeTimeout, 10*time.Second),
+    IdleConnTimeout:     seconds(config.IdleConnTimeout, 90*time.Second),
+    MaxIdleConns:        config.MaxIdleConns,
+    TLSClientConfig:     &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify},
+  }
+
+  if config.Proxy != "" {
+    proxy_url, proxy_err := url.Parse(config.Proxy)
+    if proxy_err != nil { return nil, proxy_err }
+    transport.Proxy = http.ProxyURL(proxy_url)
+  } else {
+    transport.Proxy = http.ProxyFromEnvironment
+  }
+
+  max_redirects := config.MaxRedirects
+  if max_redirects == 0 {
+    max_redirects = 10
+  }
+
+  client = &http.Client{
+    Transport: transport,
+    Timeout:   seconds(config.RequestTimeout, 30*time.Second),
+    CheckRedirect: func (req * http.Request, via [] * http.Request) (error) {
+      if !config.FollowRedirects {
+        return err_redirect_blocked
+      }
+      if len(via) >= max_redirects {
+        return fmt.Errorf("stopped after %d redirects", max_redirects)
+      }
+      return nil
+    },
+  }
+
+  return
+}
+
+func seconds (n float64, fallback time.Duration) (time.Duration) {
+  if n <= 0 {
+    return fallback
+  }
+  return time.Duration(n * float64(time.Second))
+}
+
+// do_get issues a GET for target through c.http_client, retrying 5xx
+// responses and network errors with exponential backoff up to
+// c.retry_count times. A blocked redirect (see err_redirect_blocked) is
+// returned immediately, untouched by the retry loop, along with its
+// response so the caller can inspect the Location header.
+func (c * Crawler) do_get (target * url.URL) (resp * http.Response, err error) {
+  delay := c.retry_backoff
+
+  for attempt := 0; attempt <= c.retry_count; attempt ++ {
+    req, req_err := http.NewRequest("GET", target.String(), nil)
+    if req_err != nil { return nil, req_err }
+    if c.user_agent != "" {
+      req.Header.Set("User-Agent", c.user_agent)
+    }
+
+    resp, err = c.http_client.Do(req)
+
+    if err != nil {
+      if is_redirect_blocked(err) {
+        return
+      }
+      if attempt < c.retry_count {
+        time.Sleep(delay)
+        delay *= 2
+        continue
+      }
+      return
+    }
+
+    if resp.StatusCode >= 500 && attempt < c.retry_count {
+      resp.Body.Close()
+      time.Sleep(delay)
+      delay *= 2
+      continue
+    }
+
+    return
+  }
+
+  return
+}
+
+// is_redirect_blocked reports whether err is the *url.Error http.Client
+// wraps around err_redirect_blocked, i.e. whether resp (still populated
+// alongside such an error, per the net/http docs) is a blocked redirect
+// rather than a genuine failure.
+func is_redirect_blocked (err error) (bool) {
+  url_err, ok := err.(*url.Error)
+  return ok && url_err.Err == err_redirect_blocked
+}
@@ -0,0 +1,282 @@
+// Package visitqueue is a durable, append-only replacement for an
+// in-memory pending-URL queue: it lets a crawl of millions of URLs run in
+// bounded RAM and pick back up where it left off after a restart.
+package visitqueue
+
+import "bufio"
+import "encoding/json"
+import "fmt"
+import "os"
+import "path/filepath"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+// Record is one pending (or formerly pending) task, serialized as a
+// single JSON line in the queue file.
+type Record struct {
+  URL   string `json:"url"`
+  Kind  int    `json:"kind"`
+  Depth int    `json:"depth"`
+}
+
+// Queue is a file-backed FIFO: Enqueue appends a record, a background
+// feeder tails the file and hands records to Dequeue over a bounded
+// channel, and the index file remembers how far processing has
+// completed so a restart resumes instead of re-reading the whole
+// backlog into memory (or silently dropping whatever was in flight).
+type Queue struct {
+  dir        string
+  queue_path string
+  index_path string
+
+  write_mu   sync.Mutex
+  write_file * os.File
+  wake       chan struct{}
+  stop       chan struct{}
+  stop_once  sync.Once
+
+  feed       chan feed_item
+
+  ack_mu        sync.Mutex
+  acked_through int64
+  acked_ahead   map[int64] bool
+}
+
+// feed_item pairs a record with its 1-based line number in the queue
+// file, so Ack can be told which line a Dequeue-d record corresponds to.
+type feed_item struct {
+  rec  Record
+  line int64
+}
+
+const feed_buffer_size   = 256
+const feed_poll_interval = 50 * time.Millisecond
+
+// Open opens (or creates) the queue and index files under dir. A freshly
+// created, empty queue file is the signal callers use to fall back to
+// seeding a new crawl rather than resuming one.
+func Open (dir string) (q * Queue, err error) {
+  if err = os.MkdirAll(dir, 0755); err != nil { return }
+
+  q = &Queue{
+    dir:         dir,
+    queue_path:  filepath.Join(dir, "queue.jsonl"),
+    index_path:  filepath.Join(dir, "queue.index"),
+    wake:        make(chan struct{}, 1),
+    stop:        make(chan struct{}),
+    feed:        make(chan feed_item, feed_buffer_size),
+    acked_ahead: make(map[int64] bool),
+  }
+
+  q.write_file, err = os.OpenFile(q.queue_path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+  if err != nil { return }
+
+  q.acked_through = q.read_index()
+
+  go q.feed_loop(q.acked_through)
+
+  return
+}
+
+// Existing reports whether a checkpoint (a non-empty queue file) is
+// already on disk for dir with un-acked work left in it, i.e. whether
+// the caller should Resume instead of Load-and-seed. A queue file is
+// append-only and never truncated, so a merely non-empty file isn't
+// enough - a crawl that ran to completion leaves a non-empty file too,
+// and treating that as "resumable" is what fed already-finished records
+// back into a fresh crawl.
+func Existing (dir string) (bool) {
+  queue_path := filepath.Join(dir, "queue.jsonl")
+
+  info, err := os.Stat(queue_path)
+  if err != nil || info.Size() == 0 {
+    return false
+  }
+
+  total, err := count_lines(queue_path)
+  if err != nil { return false }
+
+  acked := read_index_file(filepath.Join(dir, "queue.index"))
+  return total > acked
+}
+
+// count_lines counts complete (newline-terminated) lines in path,
+// matching feed_loop's own definition of "a line" so the two never
+// disagree about how far into the file a given line number reaches.
+func count_lines (path string) (n int64, err error) {
+  file, err := os.Open(path)
+  if err != nil { return }
+  defer file.Close()
+
+  reader := bufio.NewReader(file)
+  for {
+    line, rerr := reader.ReadString('\n')
+    if strings.HasSuffix(line, "\n") {
+      n ++
+    }
+    if rerr != nil { return }
+  }
+}
+
+func read_index_file (index_path string) (int64) {
+  data, err := os.ReadFile(index_path)
+  if err != nil { return 0 }
+
+  n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+  if err != nil { return 0 }
+  return n
+}
+
+func (q * Queue) read_index () (int64) {
+  return read_index_file(q.index_path)
+}
+
+func (q * Queue) write_index (n int64) {
+  tmp := q.index_path + ".tmp"
+  os.WriteFile(tmp, []byte(fmt.Sprintf("%d", n)), 0644)
+  os.Rename(tmp, q.index_path)
+}
+
+// PendingCount reports how many records in the queue file have not yet
+// been acked, whether or not they were ever fed - i.e. how many Run needs to seed its
+// completion accounting with when resuming, since those records won't
+// be re-enqueued and nothing else will ever count them in.
+func (q * Queue) PendingCount () (int64, error) {
+  total, err := count_lines(q.queue_path)
+  if err != nil { return 0, err }
+
+  q.ack_mu.Lock()
+  acked := q.acked_through
+  q.ack_mu.Unlock()
+
+  pending := total - acked
+  if pending < 0 { pending = 0 }
+  return pending, nil
+}
+
+// feed_loop tails the queue file, skipping its first already_fed lines
+// (Open passes the acked count, so records that were fed but never acked
+// are fed again), forwarding each later record onto q.feed as it becomes
+// available.
+// It buffers any partial (not yet newline-terminated) tail across reads
+// instead of discarding it, since a reader can otherwise observe a line
+// mid-append and the remainder would be misparsed as its own line once
+// the writer finishes it.
+func (q * Queue) feed_loop (already_fed int64) {
+  file, err := os.Open(q.queue_path)
+  if err != nil { close(q.feed); return }
+  defer file.Close()
+
+  reader := bufio.NewReader(file)
+  var line_no int64 = 0
+  var partial strings.Builder
+
+  for {
+    chunk, err := reader.ReadString('\n')
+    partial.WriteString(chunk)
+
+    if strings.HasSuffix(chunk, "\n") {
+      line := partial.String()
+      partial.Reset()
+
+      line_no ++
+      if line_no > already_fed {
+        var rec Record
+        if json.Unmarshal([]byte(line), &rec) == nil {
+          select {
+          case q.feed <- feed_item{rec: rec, line: line_no}:
+          case <-q.stop:
+            close(q.feed)
+            return
+          }
+        }
+      }
+    } else if err != nil {
+      select {
+      case <-q.wake:
+      case <-time.After(feed_poll_interval):
+      case <-q.stop:
+        close(q.feed)
+        return
+      }
+    }
+  }
+}
+
+// Enqueue durably appends a record to the queue file.
+func (q * Queue) Enqueue (rec Record) (err error) {
+  q.write_mu.Lock()
+  defer q.write_mu.Unlock()
+
+  line, err := json.Marshal(rec)
+  if err != nil { return }
+
+  _, err = q.write_file.Write(append(line, '\n'))
+  if err != nil { return }
+
+  select {
+  case q.wake <- struct{}{}:
+  default:
+  }
+  return
+}
+
+// Dequeue blocks until a pending record is available, returning its line
+// number alongside it so the caller can Ack it once fully processed.
+func (q * Queue) Dequeue () (Record, int64, bool) {
+  item, ok := <-q.feed
+  return item.rec, item.line, ok
+}
+
+// Ack reports that the record fed at line has been fully processed.
+// Checkpoint only ever persists a contiguous prefix of completed lines,
+// so Acks arriving out of order (workers don't finish in feed order) are
+// held in acked_ahead until the gap in front of them closes.
+func (q * Queue) Ack (line int64) {
+  q.ack_mu.Lock()
+  defer q.ack_mu.Unlock()
+
+  if line <= q.acked_through {
+    return
+  }
+
+  if line != q.acked_through + 1 {
+    q.acked_ahead[line] = true
+    return
+  }
+
+  q.acked_through = line
+  for q.acked_ahead[q.acked_through + 1] {
+    delete(q.acked_ahead, q.acked_through + 1)
+    q.acked_through ++
+  }
+}
+
+// Checkpoint persists how far processing has completed - not merely how
+// far the feeder has read - so a resume never skips a record that was
+// fed but not yet acked (e.g. still sitting in the feed buffer or
+// in-flight on a worker) and never needlessly re-feeds one that was
+// already finished. Callers decide the cadence (e.g. every N enqueues)
+// since fsyncing on every record would be far too slow for a large
+// crawl.
+func (q * Queue) Checkpoint () {
+  q.ack_mu.Lock()
+  n := q.acked_through
+  q.ack_mu.Unlock()
+  q.write_index(n)
+}
+
+// Stop signals the feeder to close the feed channel once it next gets a
+// chance to, unblocking every Dequeue call. Run calls this after the
+// crawl has fully drained so worker goroutines can exit instead of
+// leaking forever blocked on an empty channel.
+func (q * Queue) Stop () {
+  q.stop_once.Do(func(){ close(q.stop) })
+}
+
+// Close flushes the underlying file handle.
+func (q * Queue) Close () (error) {
+  return q.write_file.Close()
+}
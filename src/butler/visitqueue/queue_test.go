@@ -0,0 +1,77 @@
+package visitqueue
+
+import "fmt"
+import "os"
+import "testing"
+
+func TestResumeRedeliversUnackedRecords (t * testing.T) {
+  dir, err := os.MkdirTemp("", "visitqueue")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(dir)
+
+  q, err := Open(dir)
+  if err != nil { t.Fatal(err) }
+
+  for i := 0; i < 3; i ++ {
+    err := q.Enqueue(Record{URL: fmt.Sprintf("http://example.com/%d", i)})
+    if err != nil { t.Fatal(err) }
+  }
+
+  // Fully process the first two records (feed then ack), but only feed
+  // the third - simulating a crash after it was handed to a worker but
+  // before that worker finished it.
+  for i := 0; i < 2; i ++ {
+    rec, line, ok := q.Dequeue()
+    if !ok { t.Fatalf("expected a record, got closed feed") }
+    if rec.URL != fmt.Sprintf("http://example.com/%d", i) { t.Fatalf("unexpected record: %+v", rec) }
+    q.Ack(line)
+  }
+
+  unacked, _, ok := q.Dequeue()
+  if !ok { t.Fatalf("expected the third record, got closed feed") }
+  if unacked.URL != "http://example.com/2" { t.Fatalf("unexpected record: %+v", unacked) }
+
+  q.Checkpoint()
+  q.Close()
+
+  if !Existing(dir) {
+    t.Fatalf("expected Existing to report un-acked work left for the third record")
+  }
+
+  q2, err := Open(dir)
+  if err != nil { t.Fatal(err) }
+  defer q2.Close()
+
+  pending, err := q2.PendingCount()
+  if err != nil { t.Fatal(err) }
+  if pending != 1 {
+    t.Fatalf("expected 1 pending record after resume, got %d", pending)
+  }
+
+  redelivered, _, ok := q2.Dequeue()
+  if !ok { t.Fatalf("expected the unacked record to be redelivered, got closed feed") }
+  if redelivered.URL != "http://example.com/2" {
+    t.Fatalf("expected the unacked record to be redelivered, got: %+v", redelivered)
+  }
+}
+
+func TestExistingIsFalseAfterACompletedCrawl (t * testing.T) {
+  dir, err := os.MkdirTemp("", "visitqueue")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(dir)
+
+  q, err := Open(dir)
+  if err != nil { t.Fatal(err) }
+
+  if err := q.Enqueue(Record{URL: "http://example.com/"}); err != nil { t.Fatal(err) }
+
+  _, line, ok := q.Dequeue()
+  if !ok { t.Fatalf("expected a record, got closed feed") }
+  q.Ack(line)
+  q.Checkpoint()
+  q.Close()
+
+  if Existing(dir) {
+    t.Fatalf("expected Existing to report no resumable work once every record has been acked")
+  }
+}
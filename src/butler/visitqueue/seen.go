@@ -0,0 +1,94 @@
+package visitqueue
+
+import "encoding/binary"
+import "hash/fnv"
+import "io/ioutil"
+import "os"
+import "sync"
+
+// SeenSet is a small on-disk bloom filter used to remember which URLs have
+// already been enqueued, without keeping every URL in RAM. False positives
+// are possible (an occasional URL gets skipped as "already seen" when it
+// wasn't) which is an acceptable trade for crawls of millions of pages;
+// false negatives never happen.
+type SeenSet struct {
+  path string
+  bits [] byte
+  k    uint
+  mu   sync.Mutex
+}
+
+const seen_default_bits = 1 << 24 // 2MB of bits ~= 16M slots
+const seen_default_k    = 4
+
+// OpenSeenSet loads path's bitset if it exists, or starts a fresh one
+// sized for large crawls.
+func OpenSeenSet (path string) (s * SeenSet, err error) {
+  s = &SeenSet{path: path, k: seen_default_k}
+
+  data, err := ioutil.ReadFile(path)
+  if err != nil {
+    if os.IsNotExist(err) {
+      s.bits = make([]byte, seen_default_bits/8)
+      err = nil
+      return
+    }
+    return
+  }
+
+  s.bits = data
+  return
+}
+
+func (s * SeenSet) hashes (key string) ([]uint32) {
+  out := make([]uint32, s.k)
+  for i := uint(0); i < s.k; i ++ {
+    h := fnv.New32a()
+    h.Write([]byte(key))
+    var seed [4]byte
+    binary.LittleEndian.PutUint32(seed[:], uint32(i))
+    h.Write(seed[:])
+    out[i] = h.Sum32() % uint32(len(s.bits)*8)
+  }
+  return out
+}
+
+// Test reports whether key has (probably) already been added.
+func (s * SeenSet) Test (key string) (bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  for _, idx := range s.hashes(key) {
+    if s.bits[idx/8]&(1<<(idx%8)) == 0 {
+      return false
+    }
+  }
+  return true
+}
+
+// Add records key as seen. Returns true if key was not already present
+// (mirroring the map[string]bool "present" check it replaces).
+func (s * SeenSet) Add (key string) (added bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  added = false
+  for _, idx := range s.hashes(key) {
+    if s.bits[idx/8]&(1<<(idx%8)) == 0 {
+      added = true
+    }
+    s.bits[idx/8] |= 1 << (idx % 8)
+  }
+  return
+}
+
+// Flush persists the bitset to disk so a restart can pick up where the
+// crawl left off.
+func (s * SeenSet) Flush () (err error) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  tmp := s.path + ".tmp"
+  if err = ioutil.WriteFile(tmp, s.bits, 0644); err != nil { return }
+  return os.Rename(tmp, s.path)
+}